@@ -4,18 +4,31 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
 	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
+//WithContext attaches ctx to db as the session context, so that otgorm (and
+//any other context-aware callback) picks it up as the parent for spans
+//started during calls made with the returned *gorm.DB. It is a thin wrapper
+//around (*gorm.DB).WithContext, kept as a named export for callers who
+//otherwise depend on otgorm alone for context propagation.
+func WithContext(ctx context.Context, db *gorm.DB) *gorm.DB {
+	return db.WithContext(ctx)
+}
+
 //Attributes that may or may not be added to a span based on Options used
 const (
-	TableKey = attribute.Key("gorm.table") //The table the GORM query is acting upon
-	QueryKey = attribute.Key("gorm.query") //The GORM query itself
+	TableKey        = attribute.Key("gorm.table")       //Deprecated: use semconv.DBSQLTableKey instead
+	QueryKey        = attribute.Key("gorm.query")       //Deprecated: use semconv.DBStatementKey instead
+	RowsAffectedKey = attribute.Key("db.rows_affected") //Number of rows affected by the statement; recorded on every span regardless of Options
 )
 
 type callbacks struct {
@@ -26,20 +39,41 @@ type callbacks struct {
 	//Record the DB query as a KeyValue onto the span where the DB is called
 	query bool
 
+	//When set, the recorded query omits interpolated variables, recording
+	//only scope.Statement.SQL.String()
+	withoutQueryVariables bool
+
+	//When set, the recorded query is passed through this function before
+	//being attached to the span
+	queryFormatter func(string) string
+
 	//Record the table that the sql query is acting on
 	table bool
 
+	//dbName is recorded as the semconv db.name attribute and on DBStats metrics
+	dbName string
+
 	//List of default attributes to include onto the span for DB calls
 	defaultAttributes []attribute.KeyValue
 
 	//tracer creates spans. This is required
 	tracer trace.Tracer
 
+	//meterProvider, when set, enables periodic publishing of sql.DB.Stats()
+	meterProvider metric.MeterProvider
+
+	//errorClassifier, when set, is consulted for every non-nil scope.Error and
+	//can override or suppress its span status, but never suppresses RecordError
+	errorClassifier func(error) (codes.Code, string, bool)
+
 	//List of default options spans will start with
 	spanOptions []trace.SpanStartOption
 }
 
-//Gorm scope keys for passing around context and span within the DB scope
+//Gorm scope keys for passing around context and span within the DB scope.
+//contextScopeKey is deprecated in favor of gorm's own (*DB).WithContext, which
+//populates Statement.Context directly; it is only consulted as a fallback,
+//see (*callbacks).before.
 var (
 	contextScopeKey = "_otContext"
 	spanScopeKey    = "_otSpan"
@@ -73,6 +107,40 @@ func WithTracer(tracer trace.Tracer) OptionFunc {
 	}
 }
 
+//WithDBName sets the semconv db.name attribute recorded on every span, and
+//the DBStats metrics published via WithMeterProvider.
+func WithDBName(name string) OptionFunc {
+	return func(c *callbacks) {
+		c.dbName = name
+	}
+}
+
+//WithAttributes adds arbitrary attributes to every span, e.g. semconv.DBUserKey
+//or semconv.NetPeerNameKey, which otgorm cannot infer from the Dialector alone.
+func WithAttributes(attrs ...attribute.KeyValue) OptionFunc {
+	return func(c *callbacks) {
+		c.defaultAttributes = append(c.defaultAttributes, attrs...)
+	}
+}
+
+//WithMeterProvider opts into publishing sql.DB.Stats() (connection pool gauges
+//and wait stats) as OTel metrics tagged with the db.name attribute set via WithDBName.
+func WithMeterProvider(mp metric.MeterProvider) OptionFunc {
+	return func(c *callbacks) {
+		c.meterProvider = mp
+	}
+}
+
+//WithErrorClassifier overrides how scope.Error maps to a span status; fn
+//returns the code, message, and whether to mark the span as errored at all
+//(false leaves status Unset, e.g. for driver errors the caller already
+//handles, but the error is still recorded as a span event via RecordError).
+func WithErrorClassifier(fn func(error) (codes.Code, string, bool)) OptionFunc {
+	return func(c *callbacks) {
+		c.errorClassifier = fn
+	}
+}
+
 // AllowRoot allows creating root spans in the absence of existing spans.
 type AllowRoot bool
 
@@ -87,6 +155,23 @@ func (q Query) apply(c *callbacks) {
 	c.query = bool(q)
 }
 
+//WithoutQueryVariables records only scope.Statement.SQL.String(), the
+//statement with its parameter placeholders, as the db.statement attribute,
+//instead of the fully-interpolated SQL produced by Dialector.Explain.
+func WithoutQueryVariables() OptionFunc {
+	return func(c *callbacks) {
+		c.withoutQueryVariables = true
+	}
+}
+
+//WithQueryFormatter passes the recorded db.statement attribute through fn
+//before attaching it to the span, e.g. to redact or normalize SQL.
+func WithQueryFormatter(fn func(string) string) OptionFunc {
+	return func(c *callbacks) {
+		c.queryFormatter = fn
+	}
+}
+
 //Table allows for recording the table affected by sql queries in spans
 type Table bool
 
@@ -101,6 +186,25 @@ func (d DefaultAttributes) apply(c *callbacks) {
 	c.defaultAttributes = []attribute.KeyValue(d)
 }
 
+//dbSystem derives the semconv db.system attribute from a GORM dialector name,
+//passing unrecognized dialectors through as-is.
+func dbSystem(dialectorName string) attribute.KeyValue {
+	switch strings.ToLower(dialectorName) {
+	case "mysql":
+		return semconv.DBSystemMySQL
+	case "postgres", "postgresql":
+		return semconv.DBSystemPostgreSQL
+	case "sqlite":
+		return semconv.DBSystemSqlite
+	case "sqlserver":
+		return semconv.DBSystemMSSQL
+	case "clickhouse":
+		return semconv.DBSystemKey.String("clickhouse")
+	default:
+		return semconv.DBSystemKey.String(dialectorName)
+	}
+}
+
 // RegisterCallbacks registers the necessary callbacks in Gorm's hook system for instrumentation with OpenTelemetry Spans.
 func RegisterCallbacks(db *gorm.DB, opts ...Option) {
 	c := &callbacks{
@@ -115,6 +219,11 @@ func RegisterCallbacks(db *gorm.DB, opts ...Option) {
 		opt.apply(c)
 	}
 
+	c.defaultAttributes = append(c.defaultAttributes, dbSystem(db.Dialector.Name()))
+	if c.dbName != "" {
+		c.defaultAttributes = append(c.defaultAttributes, semconv.DBNameKey.String(c.dbName))
+	}
+
 	db.Callback().Create().Before("gorm:create").Register("before_create", c.beforeCreate)
 	db.Callback().Create().After("gorm:create").Register("after_create", c.afterCreate)
 	db.Callback().Query().Before("gorm:query").Register("before_query", c.beforeQuery)
@@ -123,17 +232,47 @@ func RegisterCallbacks(db *gorm.DB, opts ...Option) {
 	db.Callback().Update().After("gorm:update").Register("after_update", c.afterUpdate)
 	db.Callback().Delete().Before("gorm:delete").Register("before_delete", c.beforeDelete)
 	db.Callback().Delete().After("gorm:delete").Register("after_delete", c.afterDelete)
+	db.Callback().Row().Before("gorm:row").Register("before_row", c.beforeRow)
+	db.Callback().Row().After("gorm:row").Register("after_row", c.afterRow)
+	db.Callback().Raw().Before("gorm:raw").Register("before_raw", c.beforeRaw)
+	db.Callback().Raw().After("gorm:raw").Register("after_raw", c.afterRaw)
+
+	if c.meterProvider != nil {
+		if sqlDB, err := db.DB(); err == nil {
+			c.registerDBStats(sqlDB)
+		}
+	}
 }
 
 func (c *callbacks) before(scope *gorm.DB, operation string) {
-	rctx, _ := scope.Get(contextScopeKey)
-	ctx, ok := rctx.(context.Context)
-	if !ok || ctx == nil {
+	if scope.Statement.DryRun {
+		return
+	}
+
+	ctx := scope.Statement.Context
+	if ctx == nil {
 		ctx = context.Background()
 	}
 
+	// Deprecated: fall back to the scope-key stash for callers that haven't
+	// migrated to db.WithContext(ctx)/Session{Context: ctx} yet. Only
+	// consulted when the statement's own context carries no parent span, so
+	// it never overrides a session context set the idiomatic way. Remove
+	// after one release.
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		if rctx, ok := scope.Get(contextScopeKey); ok {
+			if kctx, ok := rctx.(context.Context); ok && kctx != nil {
+				ctx = kctx
+			}
+		}
+	}
+
 	ctx = c.startTrace(ctx, scope, operation)
 
+	// Write the traced context back onto the statement so that any
+	// database/sql driver instrumentation (e.g. otelsql) picks up this span
+	// as its parent.
+	scope.Statement.Context = ctx
 	scope.Set(contextScopeKey, ctx)
 }
 
@@ -158,13 +297,44 @@ func (c *callbacks) startTrace(ctx context.Context, scope *gorm.DB, operation st
 	ctx, span = c.tracer.Start(
 		ctx,
 		fmt.Sprintf("gorm:%s", operation),
-		c.spanOptions...,
+		append(c.spanOptions, trace.WithAttributes(semconv.DBOperationKey.String(operation)))...,
 	)
 	scope.Set(spanScopeKey, span)
 
 	return ctx
 }
 
+//formatQuery renders the SQL to record as the db.statement attribute,
+//honoring WithoutQueryVariables and WithQueryFormatter.
+func (c *callbacks) formatQuery(scope *gorm.DB) string {
+	sql := scope.Statement.SQL.String()
+	if !c.withoutQueryVariables {
+		sql = scope.Statement.Dialector.Explain(sql, scope.Statement.Vars...)
+	}
+
+	if c.queryFormatter != nil {
+		sql = c.queryFormatter(sql)
+	}
+
+	return sql
+}
+
+//classifyError maps a non-nil scope.Error to a span status code, message,
+//and whether the span's status should be set to Error at all; it does not
+//gate RecordError, which endTrace always calls for a non-nil error.
+//gorm.ErrRecordNotFound is expected control flow by default.
+func (c *callbacks) classifyError(err error) (code codes.Code, message string, isError bool) {
+	if c.errorClassifier != nil {
+		return c.errorClassifier(err)
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return codes.Unset, "", false
+	}
+
+	return codes.Error, err.Error(), true
+}
+
 func (c *callbacks) endTrace(scope *gorm.DB) {
 	rspan, ok := scope.Get(spanScopeKey)
 	if !ok {
@@ -177,32 +347,27 @@ func (c *callbacks) endTrace(scope *gorm.DB) {
 	}
 
 	//Apply span attributes
-	attributes := c.defaultAttributes
+	attributes := append([]attribute.KeyValue{}, c.defaultAttributes...)
 
 	if c.table {
-		attributes = append(attributes, TableKey.String(scope.Statement.Table))
+		attributes = append(attributes, semconv.DBSQLTableKey.String(scope.Statement.Table))
 	}
 
 	if c.query {
-		attributes = append(attributes, QueryKey.String(scope.Statement.SQL.String()))
+		attributes = append(attributes, semconv.DBStatementKey.String(c.formatQuery(scope)))
 	}
+
+	attributes = append(attributes, RowsAffectedKey.Int64(scope.Statement.RowsAffected))
+
 	span.SetAttributes(attributes...)
 
-	//Set StatusCode if there are any issues
-
-	if scope.Error != nil {
-		var code codes.Code
-		var message string
-		err := scope.Error
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			code = codes.Error
-			message = "not found"
-		} else {
-			code = codes.Unset
-			message = "unknown"
-		}
+	//Record and classify any error on the span
+	if err := scope.Error; err != nil {
+		span.RecordError(err)
 
-		span.SetStatus(code, message)
+		if code, message, isError := c.classifyError(err); isError {
+			span.SetStatus(code, message)
+		}
 	}
 
 	span.End()
@@ -216,3 +381,7 @@ func (c *callbacks) beforeUpdate(scope *gorm.DB) { c.before(scope, "update") }
 func (c *callbacks) afterUpdate(scope *gorm.DB)  { c.after(scope) }
 func (c *callbacks) beforeDelete(scope *gorm.DB) { c.before(scope, "delete") }
 func (c *callbacks) afterDelete(scope *gorm.DB)  { c.after(scope) }
+func (c *callbacks) beforeRow(scope *gorm.DB)    { c.before(scope, "row") }
+func (c *callbacks) afterRow(scope *gorm.DB)     { c.after(scope) }
+func (c *callbacks) beforeRaw(scope *gorm.DB)    { c.before(scope, "raw") }
+func (c *callbacks) afterRaw(scope *gorm.DB)     { c.after(scope) }
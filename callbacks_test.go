@@ -0,0 +1,375 @@
+package otgorm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type testModel struct {
+	ID   uint
+	Name string
+}
+
+//newTestDB opens an in-memory sqlite DB, migrates testModel, and registers
+//otgorm with a tracer backed by an in-memory span recorder so tests can
+//assert on what was exported.
+func newTestDB(t *testing.T, opts ...Option) (*gorm.DB, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm.Open: %v", err)
+	}
+	if err := db.AutoMigrate(&testModel{}); err != nil {
+		t.Fatalf("AutoMigrate: %v", err)
+	}
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	RegisterCallbacks(db, append([]Option{WithTracer(tp.Tracer("test")), AllowRoot(true)}, opts...)...)
+
+	return db, exporter
+}
+
+//dbStatement returns the db.statement attribute recorded on the single span
+//held by exporter, failing the test if there isn't exactly one.
+func dbStatement(t *testing.T, exporter *tracetest.InMemoryExporter) string {
+	t.Helper()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected exactly one span, got %d", len(spans))
+	}
+
+	for _, attr := range spans[0].Attributes {
+		if attr.Key == semconv.DBStatementKey {
+			return attr.Value.AsString()
+		}
+	}
+
+	t.Fatalf("no %s attribute on span", semconv.DBStatementKey)
+	return ""
+}
+
+func TestQueryFormatting(t *testing.T) {
+	t.Run("default interpolates variables", func(t *testing.T) {
+		db, exporter := newTestDB(t, Query(true))
+
+		db.Where("name = ?", "alice").Find(&[]testModel{})
+
+		if got := dbStatement(t, exporter); !strings.Contains(got, "alice") {
+			t.Fatalf("db.statement = %q, want it to contain the interpolated value", got)
+		}
+	})
+
+	t.Run("WithoutQueryVariables keeps placeholders", func(t *testing.T) {
+		db, exporter := newTestDB(t, Query(true), WithoutQueryVariables())
+
+		db.Where("name = ?", "alice").Find(&[]testModel{})
+
+		if got := dbStatement(t, exporter); strings.Contains(got, "alice") {
+			t.Fatalf("db.statement = %q, want placeholders only, not the interpolated value", got)
+		}
+	})
+
+	t.Run("WithQueryFormatter post-processes the statement", func(t *testing.T) {
+		db, exporter := newTestDB(t, Query(true), WithQueryFormatter(func(string) string { return "REDACTED" }))
+
+		db.Where("name = ?", "alice").Find(&[]testModel{})
+
+		if got := dbStatement(t, exporter); got != "REDACTED" {
+			t.Fatalf("db.statement = %q, want %q", got, "REDACTED")
+		}
+	})
+}
+
+func TestSpanAttributes(t *testing.T) {
+	db, exporter := newTestDB(t, Table(true))
+
+	if err := db.Create(&testModel{Name: "alice"}).Error; err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	span := singleSpan(t, exporter)
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, attr := range span.Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+
+	if got, want := attrs[semconv.DBSystemKey], semconv.DBSystemSqlite.Value; got != want {
+		t.Fatalf("db.system = %v, want %v", got, want)
+	}
+	if got := attrs[RowsAffectedKey]; got.AsInt64() != 1 {
+		t.Fatalf("db.rows_affected = %v, want 1", got)
+	}
+}
+
+//dataPointAttributes returns the attribute.Set of an aggregation's first
+//data point, regardless of whether it's a Gauge or a Sum.
+func dataPointAttributes(t *testing.T, data metricdata.Aggregation) attribute.Set {
+	t.Helper()
+
+	switch agg := data.(type) {
+	case metricdata.Gauge[int64]:
+		if len(agg.DataPoints) != 1 {
+			t.Fatalf("expected exactly one data point, got %d", len(agg.DataPoints))
+		}
+		return agg.DataPoints[0].Attributes
+	case metricdata.Sum[int64]:
+		if len(agg.DataPoints) != 1 {
+			t.Fatalf("expected exactly one data point, got %d", len(agg.DataPoints))
+		}
+		return agg.DataPoints[0].Attributes
+	default:
+		t.Fatalf("unexpected aggregation type %T", data)
+		return attribute.Set{}
+	}
+}
+
+func TestRegisterDBStats(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm.Open: %v", err)
+	}
+
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	RegisterCallbacks(db, WithMeterProvider(mp), WithDBName("testdb"))
+
+	got, err := reader.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+
+	wantNames := []string{
+		dbStatsOpenConnections,
+		dbStatsIdleConnections,
+		dbStatsInUseConnections,
+		dbStatsWaitCount,
+		dbStatsWaitDuration,
+	}
+
+	var metrics []metricdata.Metrics
+	for _, scope := range got.ScopeMetrics {
+		metrics = append(metrics, scope.Metrics...)
+	}
+
+	for _, name := range wantNames {
+		found := false
+		for _, m := range metrics {
+			if m.Name != name {
+				continue
+			}
+			found = true
+			attrs := dataPointAttributes(t, m.Data)
+			if _, ok := attrs.Value(semconv.DBNameKey); !ok {
+				t.Errorf("metric %s missing db.name attribute", name)
+			}
+		}
+		if !found {
+			t.Errorf("no metric published for instrument %s", name)
+		}
+	}
+}
+
+//singleSpan returns the single span held by exporter, failing the test if
+//there isn't exactly one.
+func singleSpan(t *testing.T, exporter *tracetest.InMemoryExporter) tracetest.SpanStub {
+	t.Helper()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected exactly one span, got %d", len(spans))
+	}
+	return spans[0]
+}
+
+func hasExceptionEvent(span tracetest.SpanStub) bool {
+	for _, event := range span.Events {
+		if event.Name == semconv.ExceptionEventName {
+			return true
+		}
+	}
+	return false
+}
+
+func TestErrorClassification(t *testing.T) {
+	t.Run("ErrRecordNotFound is recorded but leaves status Unset", func(t *testing.T) {
+		db, exporter := newTestDB(t)
+
+		var got testModel
+		err := db.First(&got, "name = ?", "nobody").Error
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("First: got err %v, want ErrRecordNotFound", err)
+		}
+
+		span := singleSpan(t, exporter)
+		if span.Status.Code != codes.Unset {
+			t.Fatalf("span status = %v, want Unset", span.Status.Code)
+		}
+		if !hasExceptionEvent(span) {
+			t.Fatalf("expected RecordError to record an exception event even for ErrRecordNotFound")
+		}
+	})
+
+	t.Run("other errors set status Error with the error message", func(t *testing.T) {
+		db, exporter := newTestDB(t)
+
+		err := db.Exec("not valid sql").Error
+		if err == nil {
+			t.Fatal("Exec: expected an error")
+		}
+
+		span := singleSpan(t, exporter)
+		if span.Status.Code != codes.Error {
+			t.Fatalf("span status = %v, want Error", span.Status.Code)
+		}
+		if span.Status.Description != err.Error() {
+			t.Fatalf("span status description = %q, want %q", span.Status.Description, err.Error())
+		}
+		if !hasExceptionEvent(span) {
+			t.Fatalf("expected RecordError to record an exception event")
+		}
+	})
+
+	t.Run("WithErrorClassifier can suppress status while RecordError still fires", func(t *testing.T) {
+		db, exporter := newTestDB(t, WithErrorClassifier(func(err error) (codes.Code, string, bool) {
+			return codes.Unset, "", false
+		}))
+
+		err := db.Exec("not valid sql").Error
+		if err == nil {
+			t.Fatal("Exec: expected an error")
+		}
+
+		span := singleSpan(t, exporter)
+		if span.Status.Code != codes.Unset {
+			t.Fatalf("span status = %v, want Unset", span.Status.Code)
+		}
+		if !hasExceptionEvent(span) {
+			t.Fatalf("expected RecordError to record an exception event even when the classifier suppresses status")
+		}
+	})
+}
+
+func TestDryRunSuppressesSpan(t *testing.T) {
+	db, exporter := newTestDB(t)
+
+	var got []testModel
+	if err := db.Session(&gorm.Session{DryRun: true}).Find(&got).Error; err != nil {
+		t.Fatalf("dry-run Find: %v", err)
+	}
+
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Fatalf("expected no spans for a dry-run statement, got %d", len(spans))
+	}
+}
+
+//TestContextPropagatedToStatement is a regression test for the bug this
+//request fixed: before() must write the started span back onto
+//scope.Statement.Context, not just the gorm scope, so that gorm's own
+//ConnPool.QueryContext/ExecContext calls (which always use
+//db.Statement.Context) see otgorm's span as their parent.
+func TestContextPropagatedToStatement(t *testing.T) {
+	db, exporter := newTestDB(t)
+
+	var probedCtx context.Context
+	db.Callback().Query().After("before_query").Register("probe_context", func(scope *gorm.DB) {
+		probedCtx = scope.Statement.Context
+	})
+
+	var got []testModel
+	if err := db.Find(&got).Error; err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+
+	probedSC := trace.SpanContextFromContext(probedCtx)
+	if !probedSC.IsValid() {
+		t.Fatalf("scope.Statement.Context after before() has no valid span context")
+	}
+
+	span := singleSpan(t, exporter)
+	if probedSC.TraceID() != span.SpanContext.TraceID() || probedSC.SpanID() != span.SpanContext.SpanID() {
+		t.Fatalf("scope.Statement.Context carries span %v, want the span otgorm started (%v)", probedSC, span.SpanContext)
+	}
+}
+
+//remoteSpanContext builds a valid, arbitrary remote span context so tests
+//can assert on which parent a started span picked, without needing a real
+//tracer to produce it.
+func remoteSpanContext(traceIDByte, spanIDByte byte) trace.SpanContext {
+	var tid trace.TraceID
+	var sid trace.SpanID
+	for i := range tid {
+		tid[i] = traceIDByte
+	}
+	for i := range sid {
+		sid[i] = spanIDByte
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    tid,
+		SpanID:     sid,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+}
+
+func TestContextPrecedence(t *testing.T) {
+	t.Run("deprecated scope key wins when Statement.Context carries no span", func(t *testing.T) {
+		db, exporter := newTestDB(t)
+
+		scopeKeySC := remoteSpanContext(0xAA, 0xAA)
+		scopeKeyCtx := trace.ContextWithSpanContext(context.Background(), scopeKeySC)
+
+		db.Callback().Query().Before("before_query").Register("stash_scope_key", func(scope *gorm.DB) {
+			scope.Set(contextScopeKey, scopeKeyCtx)
+		})
+
+		var got []testModel
+		if err := db.Find(&got).Error; err != nil {
+			t.Fatalf("Find: %v", err)
+		}
+
+		span := singleSpan(t, exporter)
+		if span.Parent.TraceID() != scopeKeySC.TraceID() {
+			t.Fatalf("span parent = %v, want the deprecated scope key's context %v", span.Parent, scopeKeySC)
+		}
+	})
+
+	t.Run("Statement.Context with a parent span wins over the scope key", func(t *testing.T) {
+		db, exporter := newTestDB(t)
+
+		scopeKeySC := remoteSpanContext(0xAA, 0xAA)
+		scopeKeyCtx := trace.ContextWithSpanContext(context.Background(), scopeKeySC)
+		db.Callback().Query().Before("before_query").Register("stash_scope_key", func(scope *gorm.DB) {
+			scope.Set(contextScopeKey, scopeKeyCtx)
+		})
+
+		sessionSC := remoteSpanContext(0xBB, 0xBB)
+		sessionCtx := trace.ContextWithSpanContext(context.Background(), sessionSC)
+
+		var got []testModel
+		if err := WithContext(sessionCtx, db).Find(&got).Error; err != nil {
+			t.Fatalf("Find: %v", err)
+		}
+
+		span := singleSpan(t, exporter)
+		if span.Parent.TraceID() != sessionSC.TraceID() {
+			t.Fatalf("span parent = %v, want the session context set via WithContext %v", span.Parent, sessionSC)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package otgorm
+
+import (
+	"context"
+	"database/sql"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric/instrument"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+//DBStats instrument names, namespaced to match the semconv-adjacent naming
+//used by other OTel SQL instrumentation (e.g. otelsql).
+const (
+	dbStatsOpenConnections  = "db.sql.connections.open"
+	dbStatsIdleConnections  = "db.sql.connections.idle"
+	dbStatsInUseConnections = "db.sql.connections.in_use"
+	dbStatsWaitCount        = "db.sql.connections.wait_count"
+	dbStatsWaitDuration     = "db.sql.connections.wait_duration"
+)
+
+//registerDBStats publishes sql.DB.Stats() as asynchronous OTel instruments on
+//c.meterProvider, tagged with the db.name attribute configured via WithDBName.
+func (c *callbacks) registerDBStats(sqlDB *sql.DB) {
+	meter := c.meterProvider.Meter("otgorm")
+
+	attrs := []attribute.KeyValue{}
+	if c.dbName != "" {
+		attrs = append(attrs, semconv.DBNameKey.String(c.dbName))
+	}
+
+	openConns, err := meter.AsyncInt64().Gauge(dbStatsOpenConnections)
+	if err != nil {
+		return
+	}
+	idleConns, err := meter.AsyncInt64().Gauge(dbStatsIdleConnections)
+	if err != nil {
+		return
+	}
+	inUseConns, err := meter.AsyncInt64().Gauge(dbStatsInUseConnections)
+	if err != nil {
+		return
+	}
+	waitCount, err := meter.AsyncInt64().Counter(dbStatsWaitCount)
+	if err != nil {
+		return
+	}
+	waitDuration, err := meter.AsyncInt64().Counter(dbStatsWaitDuration)
+	if err != nil {
+		return
+	}
+
+	instruments := []instrument.Asynchronous{openConns, idleConns, inUseConns, waitCount, waitDuration}
+
+	_ = meter.RegisterCallback(instruments, func(ctx context.Context) {
+		stats := sqlDB.Stats()
+
+		openConns.Observe(ctx, int64(stats.OpenConnections), attrs...)
+		idleConns.Observe(ctx, int64(stats.Idle), attrs...)
+		inUseConns.Observe(ctx, int64(stats.InUse), attrs...)
+		waitCount.Observe(ctx, stats.WaitCount, attrs...)
+		waitDuration.Observe(ctx, stats.WaitDuration.Milliseconds(), attrs...)
+	})
+}